@@ -1,6 +1,8 @@
 package main
 
 import (
+	"time"
+
 	log "github.com/sirupsen/logrus"
 
 	"github.com/extndr/loadBalancer/internal/config"
@@ -16,12 +18,36 @@ func main() {
 
 	cfg := config.LoadConfig()
 
-	lbInstance, err := lb.New(cfg.Backends, nil)
+	healthCheck := lb.HealthCheckConfig{
+		Path:               cfg.HealthCheckPath,
+		Interval:           cfg.HealthCheckInterval,
+		Timeout:            2 * time.Second,
+		HealthyThreshold:   cfg.HealthyThreshold,
+		UnhealthyThreshold: cfg.UnhealthyThreshold,
+	}
+
+	retry := lb.RetryConfig{
+		MaxRetries:         cfg.MaxRetries,
+		RetryUnsafeMethods: cfg.RetryUnsafeMethods,
+	}
+
+	balancer, err := lb.NewBalancer(cfg.Strategy)
+	if err != nil {
+		log.WithError(err).Fatal("invalid balancing strategy")
+	}
+
+	http2Cfg := lb.HTTP2Config{
+		ReadIdleTimeout: cfg.HTTP2ReadIdleTimeout,
+		PingTimeout:     cfg.HTTP2PingTimeout,
+		ForceH2C:        cfg.HTTP2Cleartext,
+	}
+
+	lbInstance, err := lb.New(cfg.Backends, nil, &healthCheck, &retry, balancer, &http2Cfg)
 	if err != nil {
 		log.WithError(err).Fatal("failed to create load balancer")
 	}
 
-	if err := server.Run(cfg.Port, lbInstance); err != nil {
+	if err := server.Run(cfg, lbInstance); err != nil {
 		log.WithError(err).Fatal("server exited with error")
 	}
 }
@@ -2,12 +2,70 @@ package config
 
 import (
 	"os"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/extndr/loadBalancer/internal/lb"
 )
 
 type LBConfig struct {
-	Port     string
-	Backends []string
+	Port string
+	// Backends is parsed from BACKENDS, a comma-separated list of
+	// host|weight entries (e.g. "http://a:8081|3,http://b:8082"). Weight
+	// defaults to 1 when omitted.
+	Backends []lb.BackendSpec
+	// Strategy selects the balancing policy (see lb.Strategy* constants).
+	Strategy string
+
+	// HealthCheckPath is the path probed on each backend to determine liveness.
+	HealthCheckPath string
+	// HealthCheckInterval is how often backends are probed.
+	HealthCheckInterval time.Duration
+	// HealthyThreshold is the number of consecutive successful probes required
+	// to bring a backend back into rotation.
+	HealthyThreshold int
+	// UnhealthyThreshold is the number of consecutive failed probes required
+	// to take a backend out of rotation.
+	UnhealthyThreshold int
+
+	// WaitForHealthcheckInterval is how long the server keeps failing its own
+	// /healthz after receiving a shutdown signal, giving upstream load
+	// balancers time to notice and fade this instance out of their pool
+	// before in-flight connections are cut off.
+	WaitForHealthcheckInterval time.Duration
+	// HammerTimeout is the maximum time in-flight requests are given to
+	// finish once shutdown actually begins, before the server is forced closed.
+	HammerTimeout time.Duration
+
+	// MaxRetries is how many additional backends a failed request is retried
+	// against before the last failure is returned to the client: 502 for a
+	// connection error, 504 for a timeout, or the backend's own 5xx response.
+	MaxRetries int
+	// RetryUnsafeMethods opts POST requests into the same retry behavior
+	// GET/HEAD/OPTIONS get by default.
+	RetryUnsafeMethods bool
+
+	// TLSCertFile and TLSKeyFile, if both set, terminate TLS using a static
+	// certificate/key pair instead of ACME.
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSDomains, if non-empty, enables autocert (Let's Encrypt): certificates
+	// are only issued for hosts in this allowlist.
+	TLSDomains []string
+	// TLSCacheDir is where autocert persists issued certificates across restarts.
+	TLSCacheDir string
+	// TLSEmail is passed to autocert for expiry/revocation notices.
+	TLSEmail string
+
+	// HTTP2ReadIdleTimeout is how long an HTTP/2 backend connection may sit
+	// idle before a health-check PING is sent.
+	HTTP2ReadIdleTimeout time.Duration
+	// HTTP2PingTimeout bounds how long that PING may go unanswered before
+	// the connection is evicted.
+	HTTP2PingTimeout time.Duration
+	// HTTP2Cleartext forces h2c to cleartext backends instead of HTTP/1.1.
+	HTTP2Cleartext bool
 }
 
 func LoadConfig() *LBConfig {
@@ -18,21 +76,109 @@ func LoadConfig() *LBConfig {
 	port = ":" + port
 
 	backends := os.Getenv("BACKENDS")
-	var backendList []string
+	var backendList []lb.BackendSpec
 	if backends == "" {
-		backendList = []string{
-			"http://localhost:8081",
-			"http://localhost:8082",
-			"http://localhost:8083",
+		backendList = []lb.BackendSpec{
+			{URL: "http://localhost:8081", Weight: 1},
+			{URL: "http://localhost:8082", Weight: 1},
+			{URL: "http://localhost:8083", Weight: 1},
 		}
 	} else {
-		for b := range strings.SplitSeq(backends, ",") {
-			backendList = append(backendList, strings.TrimSpace(b))
-		}
+		backendList = parseBackends(backends)
 	}
 
 	return &LBConfig{
-		Port:     port,
-		Backends: backendList,
+		Port:                       port,
+		Backends:                   backendList,
+		Strategy:                   getEnv("BALANCING_STRATEGY", lb.StrategyRoundRobin),
+		HealthCheckPath:            getEnv("HEALTH_CHECK_PATH", "/healthz"),
+		HealthCheckInterval:        getEnvSeconds("HEALTH_CHECK_INTERVAL_SECONDS", 10*time.Second),
+		HealthyThreshold:           getEnvInt("HEALTHY_THRESHOLD", 2),
+		UnhealthyThreshold:         getEnvInt("UNHEALTHY_THRESHOLD", 3),
+		WaitForHealthcheckInterval: getEnvSeconds("DRAIN_INTERVAL_SECONDS", 15*time.Second),
+		HammerTimeout:              getEnvSeconds("SHUTDOWN_TIMEOUT_SECONDS", 5*time.Second),
+		MaxRetries:                 getEnvInt("MAX_RETRIES", 2),
+		RetryUnsafeMethods:         getEnvBool("RETRY_UNSAFE_METHODS", false),
+		TLSCertFile:                getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:                 getEnv("TLS_KEY_FILE", ""),
+		TLSDomains:                 getEnvList("TLS_DOMAINS", nil),
+		TLSCacheDir:                getEnv("TLS_CACHE_DIR", "./certs"),
+		TLSEmail:                   getEnv("TLS_EMAIL", ""),
+		HTTP2ReadIdleTimeout:       getEnvSeconds("HTTP2_READ_IDLE_TIMEOUT_SECONDS", 30*time.Second),
+		HTTP2PingTimeout:           getEnvSeconds("HTTP2_PING_TIMEOUT_SECONDS", 15*time.Second),
+		HTTP2Cleartext:             getEnvBool("HTTP2_CLEARTEXT", false),
+	}
+}
+
+// parseBackends parses BACKENDS, a comma-separated list of "url" or
+// "url|weight" entries, into BackendSpecs. Weight defaults to 1 when
+// omitted or invalid.
+func parseBackends(raw string) []lb.BackendSpec {
+	var specs []lb.BackendSpec
+	for b := range strings.SplitSeq(raw, ",") {
+		b = strings.TrimSpace(b)
+		if b == "" {
+			continue
+		}
+
+		url, weight := b, 1
+		if idx := strings.LastIndex(b, "|"); idx != -1 {
+			url = strings.TrimSpace(b[:idx])
+			if w, err := strconv.Atoi(strings.TrimSpace(b[idx+1:])); err == nil && w > 0 {
+				weight = w
+			}
+		}
+
+		specs = append(specs, lb.BackendSpec{URL: url, Weight: weight})
+	}
+	return specs
+}
+
+// getEnvList parses a comma-separated env var into a trimmed string slice.
+func getEnvList(key string, def []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	var out []string
+	for p := range strings.SplitSeq(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func getEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func getEnvInt(key string, def int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+func getEnvBool(key string, def bool) bool {
+	if v := os.Getenv(key); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+func getEnvSeconds(key string, def time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return time.Duration(n) * time.Second
+		}
 	}
+	return def
 }
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/extndr/loadBalancer/internal/lb"
+)
+
+func TestParseBackendsDefaultsWeightToOne(t *testing.T) {
+	got := parseBackends("http://a:8081,http://b:8082")
+	want := []lb.BackendSpec{
+		{URL: "http://a:8081", Weight: 1},
+		{URL: "http://b:8082", Weight: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseBackends() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseBackendsParsesExplicitWeight(t *testing.T) {
+	got := parseBackends("http://a:8081|3, http://b:8082|1")
+	want := []lb.BackendSpec{
+		{URL: "http://a:8081", Weight: 3},
+		{URL: "http://b:8082", Weight: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseBackends() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseBackendsFallsBackToOneOnInvalidWeight(t *testing.T) {
+	got := parseBackends("http://a:8081|notanumber,http://b:8082|0")
+	want := []lb.BackendSpec{
+		{URL: "http://a:8081", Weight: 1},
+		{URL: "http://b:8082", Weight: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseBackends() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseBackendsSkipsEmptyEntries(t *testing.T) {
+	got := parseBackends("http://a:8081,,  ,http://b:8082")
+	want := []lb.BackendSpec{
+		{URL: "http://a:8081", Weight: 1},
+		{URL: "http://b:8082", Weight: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseBackends() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,195 @@
+package lb
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Balancing strategy names accepted by NewBalancer / the BALANCING_STRATEGY
+// environment variable.
+const (
+	StrategyRoundRobin         = "round_robin"
+	StrategyWeightedRoundRobin = "weighted_round_robin"
+	StrategyLeastConn          = "least_conn"
+	StrategyP2CEWMA            = "p2c_ewma"
+)
+
+var errNoHealthyBackends = errors.New("no healthy backends available")
+
+// Balancer selects which backend a request is routed to. Implementations
+// must be safe for concurrent use.
+type Balancer interface {
+	// pick returns an eligible backend from backends, skipping any present in
+	// excluded (already tried for this request).
+	pick(backends []*backend, excluded map[*backend]bool) (*backend, error)
+	// report feeds back the observed latency of a completed request, for
+	// balancers that use it (e.g. P2C+EWMA). No-op for the rest.
+	report(b *backend, latency time.Duration)
+}
+
+// NewBalancer constructs the Balancer for the given strategy name. An empty
+// string selects round-robin.
+func NewBalancer(strategy string) (Balancer, error) {
+	switch strategy {
+	case "", StrategyRoundRobin:
+		return &RoundRobinBalancer{}, nil
+	case StrategyWeightedRoundRobin:
+		return &WeightedRoundRobinBalancer{}, nil
+	case StrategyLeastConn:
+		return &LeastConnBalancer{}, nil
+	case StrategyP2CEWMA:
+		return &P2CEWMABalancer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown balancing strategy %q", strategy)
+	}
+}
+
+func eligibleBackends(backends []*backend, excluded map[*backend]bool) []*backend {
+	var out []*backend
+	for _, b := range backends {
+		if !excluded[b] && b.eligible() {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// RoundRobinBalancer cycles through backends in order.
+type RoundRobinBalancer struct {
+	counter uint64
+}
+
+func (rr *RoundRobinBalancer) pick(backends []*backend, excluded map[*backend]bool) (*backend, error) {
+	n := uint64(len(backends))
+	if n == 0 {
+		return nil, errNoHealthyBackends
+	}
+
+	start := atomic.AddUint64(&rr.counter, 1) - 1
+	for i := uint64(0); i < n; i++ {
+		b := backends[(start+i)%n]
+		if !excluded[b] && b.eligible() {
+			return b, nil
+		}
+	}
+
+	return nil, errNoHealthyBackends
+}
+
+func (rr *RoundRobinBalancer) report(b *backend, latency time.Duration) {}
+
+// WeightedRoundRobinBalancer implements Nginx's smooth weighted round-robin:
+// each pick adds a backend's weight to its running current-weight, hands the
+// request to whichever backend has the highest current-weight, then
+// decrements that backend by the total weight of all eligible backends. Over
+// a full cycle this spreads requests proportionally to weight without
+// bursting all of a heavy backend's traffic back-to-back.
+type WeightedRoundRobinBalancer struct {
+	mu sync.Mutex
+}
+
+func (w *WeightedRoundRobinBalancer) pick(backends []*backend, excluded map[*backend]bool) (*backend, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var best *backend
+	total := 0
+	for _, b := range backends {
+		if excluded[b] || !b.eligible() {
+			continue
+		}
+		total += b.weight
+		b.currentWeight += b.weight
+		if best == nil || b.currentWeight > best.currentWeight {
+			best = b
+		}
+	}
+	if best == nil {
+		return nil, errNoHealthyBackends
+	}
+
+	best.currentWeight -= total
+	return best, nil
+}
+
+func (w *WeightedRoundRobinBalancer) report(b *backend, latency time.Duration) {}
+
+// LeastConnBalancer routes to whichever eligible backend currently has the
+// fewest in-flight requests.
+type LeastConnBalancer struct{}
+
+func (l *LeastConnBalancer) pick(backends []*backend, excluded map[*backend]bool) (*backend, error) {
+	var best *backend
+	var bestConns int32
+
+	for _, b := range backends {
+		if excluded[b] || !b.eligible() {
+			continue
+		}
+		conns := atomic.LoadInt32(&b.activeConns)
+		if best == nil || conns < bestConns {
+			best = b
+			bestConns = conns
+		}
+	}
+	if best == nil {
+		return nil, errNoHealthyBackends
+	}
+
+	return best, nil
+}
+
+func (l *LeastConnBalancer) report(b *backend, latency time.Duration) {}
+
+// ewmaAlpha weights how much a fresh latency sample moves a backend's
+// running average; lower values smooth out noise, higher values react faster.
+const ewmaAlpha = 0.3
+
+// P2CEWMABalancer implements power-of-two-choices: sample two eligible
+// backends at random and route to whichever has the lower exponentially
+// weighted moving average of observed response time. This scales better than
+// scanning every backend's score while still avoiding the herding behavior
+// plain random choice produces.
+type P2CEWMABalancer struct {
+	mu sync.Mutex
+}
+
+func (p *P2CEWMABalancer) pick(backends []*backend, excluded map[*backend]bool) (*backend, error) {
+	candidates := eligibleBackends(backends, excluded)
+	if len(candidates) == 0 {
+		return nil, errNoHealthyBackends
+	}
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+
+	i := rand.Intn(len(candidates))
+	j := rand.Intn(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := candidates[i], candidates[j]
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if a.ewma <= b.ewma {
+		return a, nil
+	}
+	return b, nil
+}
+
+func (p *P2CEWMABalancer) report(b *backend, latency time.Duration) {
+	sample := float64(latency.Milliseconds())
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if b.ewma == 0 {
+		b.ewma = sample
+		return
+	}
+	b.ewma = ewmaAlpha*sample + (1-ewmaAlpha)*b.ewma
+}
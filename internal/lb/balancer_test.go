@@ -0,0 +1,133 @@
+package lb
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newTestBackend(t *testing.T, rawURL string, weight int) *backend {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("parse %q: %v", rawURL, err)
+	}
+	return &backend{url: u, healthy: 1, weight: weight}
+}
+
+func TestRoundRobinBalancerCyclesInOrder(t *testing.T) {
+	backends := []*backend{
+		newTestBackend(t, "http://a", 1),
+		newTestBackend(t, "http://b", 1),
+		newTestBackend(t, "http://c", 1),
+	}
+	rr := &RoundRobinBalancer{}
+	excluded := map[*backend]bool{}
+
+	want := []string{"a", "b", "c", "a", "b", "c"}
+	for i, w := range want {
+		got, err := rr.pick(backends, excluded)
+		if err != nil {
+			t.Fatalf("pick %d: %v", i, err)
+		}
+		if got.url.Host != w {
+			t.Errorf("pick %d = %s, want %s", i, got.url.Host, w)
+		}
+	}
+}
+
+func TestRoundRobinBalancerSkipsExcluded(t *testing.T) {
+	backends := []*backend{
+		newTestBackend(t, "http://a", 1),
+		newTestBackend(t, "http://b", 1),
+	}
+	rr := &RoundRobinBalancer{}
+	excluded := map[*backend]bool{backends[0]: true}
+
+	got, err := rr.pick(backends, excluded)
+	if err != nil {
+		t.Fatalf("pick: %v", err)
+	}
+	if got.url.Host != "b" {
+		t.Errorf("pick = %s, want b", got.url.Host)
+	}
+}
+
+func TestRoundRobinBalancerNoEligibleBackends(t *testing.T) {
+	backends := []*backend{newTestBackend(t, "http://a", 1)}
+	backends[0].healthy = 0
+
+	rr := &RoundRobinBalancer{}
+	if _, err := rr.pick(backends, map[*backend]bool{}); err != errNoHealthyBackends {
+		t.Fatalf("pick error = %v, want errNoHealthyBackends", err)
+	}
+}
+
+func TestWeightedRoundRobinBalancerRespectsWeight(t *testing.T) {
+	heavy := newTestBackend(t, "http://heavy", 3)
+	light := newTestBackend(t, "http://light", 1)
+	backends := []*backend{heavy, light}
+
+	w := &WeightedRoundRobinBalancer{}
+	counts := map[string]int{}
+	for i := 0; i < 8; i++ {
+		got, err := w.pick(backends, map[*backend]bool{})
+		if err != nil {
+			t.Fatalf("pick %d: %v", i, err)
+		}
+		counts[got.url.Host]++
+	}
+
+	if counts["heavy"] != 6 || counts["light"] != 2 {
+		t.Errorf("counts = %v, want heavy=6 light=2 over two full cycles", counts)
+	}
+}
+
+func TestLeastConnBalancerPicksFewestActiveConns(t *testing.T) {
+	busy := newTestBackend(t, "http://busy", 1)
+	idle := newTestBackend(t, "http://idle", 1)
+	busy.activeConns = 5
+
+	l := &LeastConnBalancer{}
+	got, err := l.pick([]*backend{busy, idle}, map[*backend]bool{})
+	if err != nil {
+		t.Fatalf("pick: %v", err)
+	}
+	if got.url.Host != "idle" {
+		t.Errorf("pick = %s, want idle", got.url.Host)
+	}
+}
+
+func TestP2CEWMABalancerReportInitializesThenSmooths(t *testing.T) {
+	b := newTestBackend(t, "http://a", 1)
+	p := &P2CEWMABalancer{}
+
+	p.report(b, 100*time.Millisecond)
+	if b.ewma != 100 {
+		t.Fatalf("ewma after first sample = %v, want 100", b.ewma)
+	}
+
+	p.report(b, 200*time.Millisecond)
+	want := ewmaAlpha*200 + (1-ewmaAlpha)*100
+	if b.ewma != want {
+		t.Fatalf("ewma after second sample = %v, want %v", b.ewma, want)
+	}
+}
+
+func TestP2CEWMABalancerPrefersLowerEWMA(t *testing.T) {
+	fast := newTestBackend(t, "http://fast", 1)
+	slow := newTestBackend(t, "http://slow", 1)
+	fast.ewma = 10
+	slow.ewma = 1000
+
+	p := &P2CEWMABalancer{}
+	for i := 0; i < 20; i++ {
+		got, err := p.pick([]*backend{fast, slow}, map[*backend]bool{})
+		if err != nil {
+			t.Fatalf("pick %d: %v", i, err)
+		}
+		if got.url.Host != "fast" {
+			t.Fatalf("pick %d = %s, want fast (lower ewma)", i, got.url.Host)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package lb
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// Exponential backoff bounds for passive circuit breaking, mirroring the
+// cenkalti/backoff defaults: start small, double on every consecutive
+// failure, cap at 30s, and reset the moment a request succeeds.
+const (
+	initialBackoff = 1 * time.Second
+	maxBackoff     = 30 * time.Second
+)
+
+// trip records a failed request against the backend and takes it out of
+// rotation for an exponentially growing (jittered) duration.
+func (b *backend) trip() {
+	prev := time.Duration(atomic.LoadInt64(&b.backoffNanos))
+	next := initialBackoff
+	if prev > 0 {
+		next = prev * 2
+		if next > maxBackoff {
+			next = maxBackoff
+		}
+	}
+	atomic.StoreInt64(&b.backoffNanos, int64(next))
+
+	jitter := time.Duration(rand.Int63n(int64(next)/2 + 1))
+	atomic.StoreInt64(&b.backoffUntil, time.Now().Add(next+jitter).UnixNano())
+	atomic.StoreUint32(&b.tripped, 1)
+}
+
+// reset clears the circuit breaker after a successful request.
+func (b *backend) reset() {
+	atomic.StoreInt64(&b.backoffNanos, 0)
+	atomic.StoreUint32(&b.tripped, 0)
+}
+
+// isTripped reports whether the backend is still serving out its backoff window.
+func (b *backend) isTripped() bool {
+	if atomic.LoadUint32(&b.tripped) == 0 {
+		return false
+	}
+	return time.Now().UnixNano() < atomic.LoadInt64(&b.backoffUntil)
+}
+
+// eligible reports whether the backend may currently receive traffic:
+// actively health-checked as up, and not serving out a backoff window.
+func (b *backend) eligible() bool {
+	return b.isHealthy() && !b.isTripped()
+}
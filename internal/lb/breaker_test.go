@@ -0,0 +1,61 @@
+package lb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackendTripBacksOffExponentiallyAndCaps(t *testing.T) {
+	b := &backend{healthy: 1}
+
+	prev := time.Duration(0)
+	for i := 0; i < 10; i++ {
+		b.trip()
+		next := time.Duration(b.backoffNanos)
+		if next < prev {
+			t.Fatalf("trip %d: backoff shrank from %v to %v", i, prev, next)
+		}
+		if next > maxBackoff {
+			t.Fatalf("trip %d: backoff %v exceeds cap %v", i, next, maxBackoff)
+		}
+		prev = next
+	}
+	if prev != maxBackoff {
+		t.Fatalf("backoff after repeated trips = %v, want cap %v", prev, maxBackoff)
+	}
+}
+
+func TestBackendTripMarksIneligibleUntilBackoffElapses(t *testing.T) {
+	b := &backend{healthy: 1}
+	b.trip()
+
+	if b.eligible() {
+		t.Fatal("backend eligible immediately after trip")
+	}
+	if !b.isTripped() {
+		t.Fatal("isTripped() = false right after trip")
+	}
+}
+
+func TestBackendResetClearsBreaker(t *testing.T) {
+	b := &backend{healthy: 1}
+	b.trip()
+	b.reset()
+
+	if b.isTripped() {
+		t.Fatal("isTripped() = true after reset")
+	}
+	if !b.eligible() {
+		t.Fatal("backend not eligible after reset")
+	}
+	if b.backoffNanos != 0 {
+		t.Fatalf("backoffNanos after reset = %d, want 0", b.backoffNanos)
+	}
+}
+
+func TestBackendEligibleRequiresHealthy(t *testing.T) {
+	b := &backend{healthy: 0}
+	if b.eligible() {
+		t.Fatal("unhealthy backend reported eligible")
+	}
+}
@@ -1,6 +1,7 @@
 package lb
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -14,117 +15,451 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// HealthCheckConfig controls active health checking of backends.
+type HealthCheckConfig struct {
+	// Path is requested on each backend to determine liveness.
+	Path string
+	// Interval is how often backends are probed.
+	Interval time.Duration
+	// Timeout bounds a single probe request.
+	Timeout time.Duration
+	// HealthyThreshold is the number of consecutive successful probes
+	// required before an unhealthy backend is returned to rotation.
+	HealthyThreshold int
+	// UnhealthyThreshold is the number of consecutive failed probes required
+	// before a backend is taken out of rotation.
+	UnhealthyThreshold int
+}
+
+// DefaultHealthCheckConfig returns sane defaults for active health checking.
+func DefaultHealthCheckConfig() HealthCheckConfig {
+	return HealthCheckConfig{
+		Path:               "/healthz",
+		Interval:           10 * time.Second,
+		Timeout:            2 * time.Second,
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 3,
+	}
+}
+
+// backend tracks the health and balancing state of a single backend
+// alongside its URL.
+type backend struct {
+	url *url.URL
+
+	// weight is the nominal weight assigned via BACKENDS=host|weight,
+	// used by WeightedRoundRobinBalancer. Defaults to 1.
+	weight int
+	// currentWeight is WeightedRoundRobinBalancer's running counter; it's
+	// only ever touched under that balancer's mutex.
+	currentWeight int
+
+	// activeConns is the number of in-flight requests currently dispatched
+	// to this backend, used by LeastConnBalancer.
+	activeConns int32
+
+	// ewma is P2CEWMABalancer's exponentially weighted moving average of
+	// observed response time in milliseconds; only touched under that
+	// balancer's mutex.
+	ewma float64
+
+	// healthy is 1 while active health checks consider the backend eligible
+	// for routing, 0 otherwise. It starts at 1 so backends are assumed
+	// healthy until proven otherwise.
+	healthy uint32
+
+	consecutiveOK   int32
+	consecutiveFail int32
+
+	// tripped is 1 while the backend's circuit breaker is open following a
+	// passive failure (connection error, 5xx, timeout).
+	tripped      uint32
+	backoffNanos int64 // current exponential backoff duration, as time.Duration
+	backoffUntil int64 // unix nanoseconds when the breaker may close again
+}
+
+func (b *backend) isHealthy() bool {
+	return atomic.LoadUint32(&b.healthy) == 1
+}
+
+// BackendSpec is a backend URL together with the weight it was configured
+// with (BACKENDS=host|weight), used by WeightedRoundRobinBalancer.
+type BackendSpec struct {
+	URL    string
+	Weight int
+}
+
 type LoadBalancer struct {
-	backends []*url.URL
-	counter  uint64
+	backends []*backend
+	balancer Balancer
 	timeout  time.Duration
 	client   *http.Client
+
+	healthCheck HealthCheckConfig
+
+	// maxRetries is how many additional backends a request may be retried
+	// against after the first one fails.
+	maxRetries int
+	// retryUnsafeMethods allows retrying non-idempotent methods (POST) that
+	// are not safe to retry by default.
+	retryUnsafeMethods bool
+
+	// draining is 1 once the instance has been asked to shut down; while set
+	// Healthz reports unhealthy so upstream load balancers stop routing here.
+	draining uint32
+}
+
+// RetryConfig controls passive failure handling: how many times a failed
+// request is retried against a different backend, and whether non-idempotent
+// methods may be retried.
+type RetryConfig struct {
+	// MaxRetries is how many additional backends to try after the first
+	// failure before giving up and returning an error to the client.
+	MaxRetries int
+	// RetryUnsafeMethods opts POST into retries. GET, HEAD, and OPTIONS are
+	// always eligible since they're safe to repeat.
+	RetryUnsafeMethods bool
+}
+
+// DefaultRetryConfig returns sane defaults for passive failure retries.
+func DefaultRetryConfig() RetryConfig {
+	return RetryConfig{MaxRetries: 2, RetryUnsafeMethods: false}
 }
 
 // New creates a new LoadBalancer.
 // backends — a list of backend URLs.
 // timeout — the maximum duration to wait for a backend response.
 // If nil is passed, a default timeout of 5 seconds is used.
-func New(backends []string, timeout *time.Duration) (*LoadBalancer, error) {
+// healthCheck — active health-check configuration. If nil, DefaultHealthCheckConfig is used.
+// retry — passive failure retry configuration. If nil, DefaultRetryConfig is used.
+// balancer — the balancing strategy to use. If nil, round-robin is used.
+// http2Cfg — HTTP/2 backend transport configuration. If nil, DefaultHTTP2Config is used.
+func New(backends []BackendSpec, timeout *time.Duration, healthCheck *HealthCheckConfig, retry *RetryConfig, balancer Balancer, http2Cfg *HTTP2Config) (*LoadBalancer, error) {
 	if len(backends) < 2 {
 		return nil, errors.New("at least 2 backends are required for load balancing")
 	}
 
-	var parsedURLs []*url.URL
-	for _, b := range backends {
-		u, err := url.Parse(b)
+	var parsedBackends []*backend
+	for _, spec := range backends {
+		u, err := url.Parse(spec.URL)
 		if err != nil {
-			return nil, fmt.Errorf("invalid backend URL %q: %w", b, err)
+			return nil, fmt.Errorf("invalid backend URL %q: %w", spec.URL, err)
 		}
 		if u.Scheme != "http" && u.Scheme != "https" {
-			return nil, fmt.Errorf("backend %q must use http/https", b)
+			return nil, fmt.Errorf("backend %q must use http/https", spec.URL)
+		}
+		weight := spec.Weight
+		if weight <= 0 {
+			weight = 1
 		}
-		parsedURLs = append(parsedURLs, u)
+		parsedBackends = append(parsedBackends, &backend{url: u, healthy: 1, weight: weight})
 	}
 
-	client := &http.Client{
-		// Custom transport to optimize connection reuse and timeouts
-		Transport: &http.Transport{
-			MaxIdleConns:        30,
-			MaxIdleConnsPerHost: 30,
-			IdleConnTimeout:     90 * time.Second,
-			DialContext: (&net.Dialer{
-				Timeout:   5 * time.Second,
-				KeepAlive: 10 * time.Second,
-			}).DialContext,
-		},
+	if http2Cfg == nil {
+		defaults := DefaultHTTP2Config()
+		http2Cfg = &defaults
 	}
 
+	// Custom transport to optimize connection reuse and timeouts
+	baseTransport := &http.Transport{
+		MaxIdleConns:        30,
+		MaxIdleConnsPerHost: 30,
+		IdleConnTimeout:     90 * time.Second,
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 10 * time.Second,
+		}).DialContext,
+	}
+
+	transport, err := newTransport(baseTransport, *http2Cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Transport: transport}
+
 	defaultTimeout := 5 * time.Second
 
 	if timeout == nil {
 		timeout = &defaultTimeout
 	}
 
+	if healthCheck == nil {
+		defaults := DefaultHealthCheckConfig()
+		healthCheck = &defaults
+	}
+
+	if retry == nil {
+		defaults := DefaultRetryConfig()
+		retry = &defaults
+	}
+
+	if balancer == nil {
+		balancer = &RoundRobinBalancer{}
+	}
+
 	return &LoadBalancer{
-		backends: parsedURLs,
-		client:   client,
-		timeout:  *timeout,
+		backends:           parsedBackends,
+		balancer:           balancer,
+		client:             client,
+		timeout:            *timeout,
+		healthCheck:        *healthCheck,
+		maxRetries:         retry.MaxRetries,
+		retryUnsafeMethods: retry.RetryUnsafeMethods,
 	}, nil
 }
 
-// getNextBackend returns the next backend in round-robin order.
-// Uses atomic counter to be safe for concurrent requests.
-func (lb *LoadBalancer) getNextBackend() *url.URL {
-	idx := atomic.AddUint64(&lb.counter, 1) - 1
-	return lb.backends[idx%uint64(len(lb.backends))]
+// maxBufferedBodyBytes caps how much of a retryable request's body is
+// buffered in memory for replay against a second backend. Larger bodies
+// fail the request rather than risking an OOM.
+const maxBufferedBodyBytes = 10 << 20 // 10 MiB
+
+// isIdempotent reports whether a method is safe to retry against a different
+// backend without an opt-in.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
 }
 
-func (lb *LoadBalancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	target := lb.getNextBackend()
+// StartHealthChecks probes every backend on the configured interval until ctx
+// is cancelled. It's meant to be run in its own goroutine.
+func (lb *LoadBalancer) StartHealthChecks(ctx context.Context) {
+	ticker := time.NewTicker(lb.healthCheck.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, b := range lb.backends {
+				go lb.probeBackend(b)
+			}
+		}
+	}
+}
 
-	// Apply timeout to backend requests to avoid hanging
-	ctx, cancel := context.WithTimeout(r.Context(), lb.timeout)
+func (lb *LoadBalancer) probeBackend(b *backend) {
+	ctx, cancel := context.WithTimeout(context.Background(), lb.healthCheck.Timeout)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, r.Method, target.String()+r.RequestURI, r.Body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.url.String()+lb.healthCheck.Path, nil)
 	if err != nil {
-		log.Errorf("failed to create request for %s: %v", target.Host, err)
-		http.Error(w, "Failed to create request", http.StatusInternalServerError)
+		log.Errorf("health check: failed to build request for %s: %v", b.url.Host, err)
 		return
 	}
-	req.Header = r.Header.Clone()
 
-	start := time.Now()
 	resp, err := lb.client.Do(req)
-	elapsed := time.Since(start)
+	ok := err == nil && resp.StatusCode < 500
+	if resp != nil {
+		resp.Body.Close()
+	}
 
-	if err != nil {
-		if errors.Is(err, context.DeadlineExceeded) {
-			log.Warnf("[timeout] %s did not respond within %ds", target.Host, int(elapsed.Seconds()))
-			http.Error(
-				w,
-				fmt.Sprintf("Backend request timed out after %ds", int(elapsed.Seconds())),
-				http.StatusGatewayTimeout,
-			)
-			return
+	if ok {
+		atomic.StoreInt32(&b.consecutiveFail, 0)
+		if !b.isHealthy() {
+			if atomic.AddInt32(&b.consecutiveOK, 1) >= int32(lb.healthCheck.HealthyThreshold) {
+				atomic.StoreUint32(&b.healthy, 1)
+				atomic.StoreInt32(&b.consecutiveOK, 0)
+				log.Infof("health check: %s is healthy again", b.url.Host)
+			}
+		}
+		return
+	}
+
+	atomic.StoreInt32(&b.consecutiveOK, 0)
+	if b.isHealthy() {
+		if atomic.AddInt32(&b.consecutiveFail, 1) >= int32(lb.healthCheck.UnhealthyThreshold) {
+			atomic.StoreUint32(&b.healthy, 0)
+			atomic.StoreInt32(&b.consecutiveFail, 0)
+			log.Warnf("health check: %s marked unhealthy: %v", b.url.Host, err)
 		}
+	}
+}
+
+// SetDraining marks the instance as draining (or not). While draining, Healthz
+// reports failure so upstream load balancers stop routing new traffic here.
+func (lb *LoadBalancer) SetDraining(draining bool) {
+	if draining {
+		atomic.StoreUint32(&lb.draining, 1)
+	} else {
+		atomic.StoreUint32(&lb.draining, 0)
+	}
+}
 
-		log.Errorf("request to %s failed: %v", target.Host, err)
-		http.Error(w, "Service temporarily unavailable", http.StatusBadGateway)
+// Healthz reports this instance's own health for upstream load balancers.
+func (lb *LoadBalancer) Healthz(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadUint32(&lb.draining) == 1 {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
 		return
 	}
-	defer resp.Body.Close()
+	w.WriteHeader(http.StatusOK)
+}
 
-	log.Infof("%s → %s %d %dms", r.Method, target.Host, resp.StatusCode, elapsed.Milliseconds())
+func (lb *LoadBalancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// CONNECT establishes a raw tunnel rather than carrying a request to
+	// proxy, so it needs the hijack path instead of http.Client.
+	if r.Method == http.MethodConnect {
+		lb.serveConnect(w, r)
+		return
+	}
 
-	// Copy response headers from the backend to the client.
-	// This preserves all headers (like Content-Type, Set-Cookie, etc.).
-	for k, v := range resp.Header {
-		for _, vv := range v {
-			w.Header().Add(k, vv)
+	// http.Client can't proxy a protocol upgrade (WebSocket, etc.) since it
+	// buffers through a Response and never exposes the raw connection —
+	// hand those off to the hijack-based path instead.
+	if isUpgradeRequest(r) {
+		lb.serveHijacked(w, r)
+		return
+	}
+
+	// Only idempotent methods are retried by default; POST needs an opt-in
+	// since replaying it against a second backend risks a double side effect.
+	retryable := isIdempotent(r.Method) || (r.Method == http.MethodPost && lb.retryUnsafeMethods)
+
+	// Buffering the body (so it can be replayed against a different backend)
+	// only matters if a retry can actually happen. Skip it otherwise and
+	// stream r.Body straight through, same as a request that's never
+	// retried — this keeps large PUT/POST uploads off the heap.
+	var bodyBytes []byte
+	if r.Body != nil && r.Body != http.NoBody && lb.maxRetries > 0 && retryable {
+		buf, err := io.ReadAll(io.LimitReader(r.Body, maxBufferedBodyBytes+1))
+		r.Body.Close()
+		if err != nil {
+			log.Errorf("failed to buffer request body: %v", err)
+			http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+			return
+		}
+		if len(buf) > maxBufferedBodyBytes {
+			http.Error(w, "Request body too large to retry", http.StatusRequestEntityTooLarge)
+			return
 		}
+		bodyBytes = buf
 	}
 
-	// Write the backend status code to the client
-	w.WriteHeader(resp.StatusCode)
+	excluded := make(map[*backend]bool)
+	attempts := lb.maxRetries + 1
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		target, err := lb.balancer.pick(lb.backends, excluded)
+		if err != nil {
+			log.Error(err)
+			http.Error(w, "Service temporarily unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		excluded[target] = true
+		last := attempt == attempts || !retryable
+
+		var body io.Reader
+		switch {
+		case bodyBytes != nil:
+			body = bytes.NewReader(bodyBytes)
+		case r.Body != nil && r.Body != http.NoBody:
+			body = r.Body
+		}
+
+		// Apply timeout to backend requests to avoid hanging
+		ctx, cancel := context.WithTimeout(r.Context(), lb.timeout)
+
+		req, err := http.NewRequestWithContext(ctx, r.Method, target.url.String()+r.RequestURI, body)
+		if err != nil {
+			cancel()
+			log.Errorf("failed to create request for %s: %v", target.url.Host, err)
+			http.Error(w, "Failed to create request", http.StatusInternalServerError)
+			return
+		}
+		req.Header = r.Header.Clone()
+		stripHopByHopHeaders(req.Header)
+		setForwardedHeaders(req.Header, r)
+		if bodyBytes != nil {
+			req.ContentLength = int64(len(bodyBytes))
+		} else {
+			req.ContentLength = r.ContentLength
+		}
+
+		atomic.AddInt32(&target.activeConns, 1)
+		start := time.Now()
+		resp, err := lb.client.Do(req)
+		elapsed := time.Since(start)
+		atomic.AddInt32(&target.activeConns, -1)
+
+		if err != nil {
+			cancel()
+
+			// context.Canceled here can mean the backend dial/request was
+			// aborted, or that the client itself hung up (r.Context() is the
+			// parent of the per-attempt context). Only the former reflects on
+			// the backend's health, so don't trip a healthy backend's breaker
+			// just because a client navigated away or aborted an XHR.
+			clientGone := errors.Is(err, context.Canceled) && r.Context().Err() != nil
+			if clientGone {
+				log.Debugf("client disconnected before %s responded", target.url.Host)
+				return
+			}
+
+			target.trip()
+
+			if !last {
+				log.Warnf("request to %s failed (%v), retrying against next backend", target.url.Host, err)
+				continue
+			}
+
+			if errors.Is(err, context.DeadlineExceeded) {
+				log.Warnf("[timeout] %s did not respond within %ds", target.url.Host, int(elapsed.Seconds()))
+				http.Error(
+					w,
+					fmt.Sprintf("Backend request timed out after %ds", int(elapsed.Seconds())),
+					http.StatusGatewayTimeout,
+				)
+				return
+			}
 
-	// Stream the backend response body to the client.
-	// io.Copy handles large responses efficiently without loading them fully into memory.
-	io.Copy(w, resp.Body)
+			log.Errorf("request to %s failed: %v", target.url.Host, err)
+			http.Error(w, "Service temporarily unavailable", http.StatusBadGateway)
+			return
+		}
+
+		if resp.StatusCode >= http.StatusInternalServerError {
+			target.trip()
+			if !last {
+				resp.Body.Close()
+				cancel()
+				log.Warnf("%s returned %d, retrying against next backend", target.url.Host, resp.StatusCode)
+				continue
+			}
+			log.Warnf("%s returned %d, retries exhausted, forwarding to client", target.url.Host, resp.StatusCode)
+		} else {
+			target.reset()
+			// Only feed latency back on genuine success — a backend that's
+			// erroring out fast (connection refused, immediate 5xx) would
+			// otherwise look artificially fast to P2C+EWMA once its circuit
+			// breaker closes again.
+			lb.balancer.report(target, elapsed)
+		}
+
+		log.Infof("%s → %s %d %dms", r.Method, target.url.Host, resp.StatusCode, elapsed.Milliseconds())
+
+		stripHopByHopHeaders(resp.Header)
+
+		// Copy response headers from the backend to the client.
+		// This preserves all headers (like Content-Type, Set-Cookie, etc.).
+		for k, v := range resp.Header {
+			for _, vv := range v {
+				w.Header().Add(k, vv)
+			}
+		}
+
+		// Write the backend status code to the client
+		w.WriteHeader(resp.StatusCode)
+
+		// Stream the backend response body to the client.
+		// io.Copy handles large responses efficiently without loading them fully into memory.
+		io.Copy(w, resp.Body)
+		resp.Body.Close()
+		cancel()
+		return
+	}
 }
@@ -0,0 +1,133 @@
+package lb
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestIsIdempotent(t *testing.T) {
+	cases := map[string]bool{
+		http.MethodGet:     true,
+		http.MethodHead:    true,
+		http.MethodOptions: true,
+		http.MethodPost:    false,
+		http.MethodPut:     false,
+		http.MethodDelete:  false,
+		http.MethodPatch:   false,
+	}
+	for method, want := range cases {
+		if got := isIdempotent(method); got != want {
+			t.Errorf("isIdempotent(%s) = %v, want %v", method, got, want)
+		}
+	}
+}
+
+func TestServeHTTPRetriesIdempotentRequestAgainstNextBackend(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	var gotMethod string
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer healthy.Close()
+
+	retry := RetryConfig{MaxRetries: 1}
+	loadBalancer, err := New(
+		[]BackendSpec{{URL: failing.URL}, {URL: healthy.URL}},
+		nil, nil, &retry, nil, nil,
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	loadBalancer.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if gotMethod != http.MethodGet {
+		t.Fatalf("healthy backend saw method %q, want GET", gotMethod)
+	}
+}
+
+func TestServeHTTPReplaysBufferedBodyOnRetry(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	var gotBody []byte
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer healthy.Close()
+
+	// RetryUnsafeMethods opts POST into retries, which is what makes this
+	// body eligible for buffering-and-replay in the first place.
+	retry := RetryConfig{MaxRetries: 1, RetryUnsafeMethods: true}
+	loadBalancer, err := New(
+		[]BackendSpec{{URL: failing.URL}, {URL: healthy.URL}},
+		nil, nil, &retry, nil, nil,
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	body := []byte("payload")
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	loadBalancer.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if !bytes.Equal(gotBody, body) {
+		t.Fatalf("healthy backend got body %q, want %q", gotBody, body)
+	}
+}
+
+func TestServeHTTPDoesNotRetryNonIdempotentWithoutOptIn(t *testing.T) {
+	var healthyCalls int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&healthyCalls, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer healthy.Close()
+
+	retry := RetryConfig{MaxRetries: 1}
+	loadBalancer, err := New(
+		[]BackendSpec{{URL: failing.URL}, {URL: healthy.URL}},
+		nil, nil, &retry, &RoundRobinBalancer{}, nil,
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte("x")))
+	rec := httptest.NewRecorder()
+	loadBalancer.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d (forwarded from first backend, no retry)", rec.Code, http.StatusInternalServerError)
+	}
+	if atomic.LoadInt32(&healthyCalls) != 0 {
+		t.Fatalf("healthy backend was called %d times, want 0: non-idempotent request must not retry", healthyCalls)
+	}
+}
@@ -0,0 +1,237 @@
+package lb
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// hopByHopHeaders must never be forwarded across a proxy hop — they describe
+// the connection to whichever peer sent them, not the resource itself.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func stripHopByHopHeaders(h http.Header) {
+	for _, name := range hopByHopHeaders {
+		h.Del(name)
+	}
+}
+
+// setForwardedHeaders records the original client and request details before
+// the request is proxied to a backend that otherwise only sees this load
+// balancer as its peer.
+func setForwardedHeaders(h http.Header, r *http.Request) {
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	clientIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		clientIP = host
+	}
+	if prior := h.Get("X-Forwarded-For"); prior != "" {
+		clientIP = prior + ", " + clientIP
+	}
+
+	h.Set("X-Forwarded-For", clientIP)
+	h.Set("X-Forwarded-Proto", proto)
+	h.Set("X-Forwarded-Host", r.Host)
+	h.Set("Forwarded", fmt.Sprintf("for=%s;proto=%s;host=%s", clientIP, proto, r.Host))
+}
+
+// isUpgradeRequest reports whether r is asking to switch protocols (e.g.
+// WebSocket), which http.Client can't proxy transparently and needs the
+// hijack path instead.
+func isUpgradeRequest(r *http.Request) bool {
+	return headerContainsToken(r.Header, "Connection", "upgrade") && r.Header.Get("Upgrade") != ""
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serveHijacked proxies a protocol-upgrade request (WebSocket, and anything
+// else that rides Connection: Upgrade) by hijacking the client connection,
+// dialing the backend directly, replaying the handshake, and then shuttling
+// raw bytes between the two until either side closes. http.Client can't be
+// used here since it doesn't expose the underlying connection once a
+// non-HTTP protocol takes over.
+func (lb *LoadBalancer) serveHijacked(w http.ResponseWriter, r *http.Request) {
+	target, err := lb.balancer.pick(lb.backends, nil)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, "Service temporarily unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		log.Error("response writer does not support hijacking, cannot proxy upgrade request")
+		http.Error(w, "Upgrade not supported", http.StatusInternalServerError)
+		return
+	}
+
+	backendConn, err := dialBackend(target.url)
+	if err != nil {
+		target.trip()
+		log.Errorf("failed to dial %s for upgrade: %v", target.url.Host, err)
+		http.Error(w, "Service temporarily unavailable", http.StatusBadGateway)
+		return
+	}
+	defer backendConn.Close()
+
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		log.Errorf("failed to hijack client connection: %v", err)
+		return
+	}
+	defer clientConn.Close()
+
+	// Connection and Upgrade must reach the backend untouched so it can
+	// complete the handshake; only the headers that never belong on a
+	// proxied hop are stripped.
+	r.Header.Del("Keep-Alive")
+	r.Header.Del("Proxy-Authenticate")
+	r.Header.Del("TE")
+	r.Header.Del("Trailer")
+	r.Header.Del("Transfer-Encoding")
+	setForwardedHeaders(r.Header, r)
+
+	if err := r.Write(backendConn); err != nil {
+		log.Errorf("failed to forward upgrade request to %s: %v", target.url.Host, err)
+		return
+	}
+
+	target.reset()
+	log.Infof("%s → %s upgraded connection", r.Method, target.url.Host)
+
+	if err := splice(clientConn, clientBuf, backendConn); err != nil {
+		log.Errorf("upgraded connection to %s ended early: %v", target.url.Host, err)
+	}
+}
+
+// serveConnect handles an HTTP CONNECT tunnel request by hijacking the
+// client connection, dialing a backend directly, acknowledging the tunnel,
+// and then splicing raw bytes between the two until either side closes.
+// Unlike serveHijacked there's no HTTP request to replay to the backend —
+// CONNECT establishes a raw byte tunnel through this load balancer, it
+// doesn't carry one.
+func (lb *LoadBalancer) serveConnect(w http.ResponseWriter, r *http.Request) {
+	target, err := lb.balancer.pick(lb.backends, nil)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, "Service temporarily unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		log.Error("response writer does not support hijacking, cannot proxy CONNECT request")
+		http.Error(w, "CONNECT not supported", http.StatusInternalServerError)
+		return
+	}
+
+	backendConn, err := dialBackend(target.url)
+	if err != nil {
+		target.trip()
+		log.Errorf("failed to dial %s for CONNECT: %v", target.url.Host, err)
+		http.Error(w, "Service temporarily unavailable", http.StatusBadGateway)
+		return
+	}
+	defer backendConn.Close()
+
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		log.Errorf("failed to hijack client connection: %v", err)
+		return
+	}
+	defer clientConn.Close()
+
+	if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		log.Errorf("failed to acknowledge CONNECT to client for %s: %v", target.url.Host, err)
+		return
+	}
+
+	target.reset()
+	log.Infof("%s → %s tunnel established", r.Method, target.url.Host)
+
+	if err := splice(clientConn, clientBuf, backendConn); err != nil {
+		log.Errorf("tunnel to %s ended early: %v", target.url.Host, err)
+	}
+}
+
+// splice flushes any client bytes already buffered by the hijack, then
+// copies bytes bidirectionally between the client and backend connections
+// until either side closes.
+func splice(clientConn net.Conn, clientBuf *bufio.ReadWriter, backendConn net.Conn) error {
+	if buffered := clientBuf.Reader.Buffered(); buffered > 0 {
+		if _, err := io.CopyN(backendConn, clientBuf.Reader, int64(buffered)); err != nil {
+			return fmt.Errorf("failed to flush buffered client bytes: %w", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(backendConn, clientConn)
+		closeWrite(backendConn)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(clientConn, backendConn)
+		closeWrite(clientConn)
+	}()
+	wg.Wait()
+
+	return nil
+}
+
+// closeWrite half-closes conn's write side, if it supports it, so the other
+// io.Copy goroutine observes EOF instead of blocking after this side is done.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+}
+
+func dialBackend(u *url.URL) (net.Conn, error) {
+	host := u.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		if u.Scheme == "https" {
+			host = net.JoinHostPort(host, "443")
+		} else {
+			host = net.JoinHostPort(host, "80")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if u.Scheme == "https" {
+		return tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+	}
+	return dialer.Dial("tcp", host)
+}
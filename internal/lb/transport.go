@@ -0,0 +1,63 @@
+package lb
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// HTTP2Config controls the HTTP/2 transport used to talk to backends.
+type HTTP2Config struct {
+	// ReadIdleTimeout is how long an HTTP/2 connection to a backend may sit
+	// idle before a health-check PING is sent. Zero disables PINGs entirely,
+	// which is how silently-dead connections (e.g. the kube-apiserver
+	// stuck-connection issue) end up hanging requests forever.
+	ReadIdleTimeout time.Duration
+	// PingTimeout bounds how long a PING may go unanswered before the
+	// connection is considered dead and evicted.
+	PingTimeout time.Duration
+	// ForceH2C makes the transport speak HTTP/2 in cleartext to backends
+	// that don't terminate TLS, instead of the default HTTP/1.1.
+	ForceH2C bool
+}
+
+// DefaultHTTP2Config returns sane defaults for HTTP/2 connection health checking.
+func DefaultHTTP2Config() HTTP2Config {
+	return HTTP2Config{
+		ReadIdleTimeout: 30 * time.Second,
+		PingTimeout:     15 * time.Second,
+	}
+}
+
+// newTransport builds the RoundTripper used to reach backends. HTTPS
+// backends negotiate HTTP/2 via ALPN automatically; cleartext backends stay
+// on HTTP/1.1 unless http2Cfg.ForceH2C is set, in which case h2c is forced
+// over a plain TCP dial.
+func newTransport(base *http.Transport, http2Cfg HTTP2Config) (http.RoundTripper, error) {
+	h2Transport, err := http2.ConfigureTransports(base)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure HTTP/2 transport: %w", err)
+	}
+	h2Transport.ReadIdleTimeout = http2Cfg.ReadIdleTimeout
+	h2Transport.PingTimeout = http2Cfg.PingTimeout
+
+	if http2Cfg.ForceH2C {
+		// Only the "http" scheme is routed to h2c; HTTPS requests stay on
+		// base and keep negotiating via ALPN.
+		base.RegisterProtocol("http", &http2.Transport{
+			AllowHTTP:       true,
+			ReadIdleTimeout: http2Cfg.ReadIdleTimeout,
+			PingTimeout:     http2Cfg.PingTimeout,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return (&net.Dialer{Timeout: 5 * time.Second, KeepAlive: 10 * time.Second}).DialContext(ctx, network, addr)
+			},
+		})
+	}
+
+	return base, nil
+}
@@ -2,48 +2,163 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"os/exec"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/extndr/loadBalancer/internal/config"
+	"github.com/extndr/loadBalancer/internal/lb"
 )
 
-func Run(addr string, handler http.Handler) error {
-	srv := &http.Server{Addr: addr, Handler: handler}
+func Run(cfg *config.LBConfig, lbInstance *lb.LoadBalancer) error {
+	ln, err := listen(cfg.Port)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", cfg.Port, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", lbInstance.Healthz)
+	mux.Handle("/", lbInstance)
+
+	srv := &http.Server{Addr: cfg.Port, Handler: mux}
+
+	useACME := len(cfg.TLSDomains) > 0
+	useStaticTLS := !useACME && cfg.TLSCertFile != "" && cfg.TLSKeyFile != ""
+
+	// A previous generation may have inherited an ACME challenge listener
+	// (fd 4) that this generation's config no longer wants; adopt and close
+	// it rather than leaving it open and unused for the process's lifetime.
+	if !useACME && inheritedFDCount() >= 2 {
+		closeInheritedFD(4)
+	}
+
+	var challengeSrv *http.Server
+	var challengeLn net.Listener
+	if useACME {
+		certManager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.TLSDomains...),
+			Cache:      autocert.DirCache(cfg.TLSCacheDir),
+			Email:      cfg.TLSEmail,
+		}
+		srv.TLSConfig = &tls.Config{GetCertificate: certManager.GetCertificate}
+
+		// certManager.HTTPHandler serves the ACME HTTP-01 challenge itself and
+		// falls through to the given handler for everything else, so plain
+		// HTTP traffic on :80 gets redirected to HTTPS.
+		challengeSrv = &http.Server{
+			Addr: ":80",
+			Handler: certManager.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				target := "https://" + r.Host + r.URL.RequestURI()
+				http.Redirect(w, r, target, http.StatusMovedPermanently)
+			})),
+		}
+
+		// Needs the same fd-inheritance treatment as the main listener: a
+		// re-exec'd child that has to bind :80 fresh will fail with "address
+		// already in use" while the parent is still draining, breaking
+		// zero-downtime restart whenever ACME is enabled.
+		challengeLn, err = listenChallenge(challengeSrv.Addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s for ACME challenge: %w", challengeSrv.Addr, err)
+		}
+	}
 
-	errChan := make(chan error, 1)
+	healthCheckCtx, stopHealthChecks := context.WithCancel(context.Background())
+	go lbInstance.StartHealthChecks(healthCheckCtx)
+
+	errChan := make(chan error, 2)
 
 	go func() {
 		log.Info("──────────────────────────────────────────────")
-		log.Infof("Load balancer started on %s", addr)
+		log.Infof("Load balancer started on %s", cfg.Port)
 		log.Info("──────────────────────────────────────────────")
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+
+		var err error
+		switch {
+		case useACME:
+			err = srv.ServeTLS(ln, "", "")
+		case useStaticTLS:
+			err = srv.ServeTLS(ln, cfg.TLSCertFile, cfg.TLSKeyFile)
+		default:
+			err = srv.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
 			errChan <- fmt.Errorf("server failed: %w", err)
 		}
 	}()
 
-	// Wait for interrupt signal to gracefully shut down the server
+	if challengeSrv != nil {
+		go func() {
+			log.Info("ACME HTTP-01 challenge listener started on :80")
+			if err := challengeSrv.Serve(challengeLn); err != nil && err != http.ErrServerClosed {
+				errChan <- fmt.Errorf("acme challenge server failed: %w", err)
+			}
+		}()
+	}
+
+	// Wait for interrupt signal to gracefully shut down the server, or SIGHUP
+	// / SIGUSR2 to hand the listening socket to a freshly re-exec'd binary
+	// and drain behind it (zero-downtime redeploy).
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
-	select {
-	case <-stop:
-		log.Info("Shutting down gracefully...")
-	case err := <-errChan:
-		return err
+	restart := make(chan os.Signal, 1)
+	signal.Notify(restart, syscall.SIGHUP, syscall.SIGUSR2)
+
+runLoop:
+	for {
+		select {
+		case <-stop:
+			log.Info("Draining: failing /healthz so upstream load balancers fade us out...")
+			lbInstance.SetDraining(true)
+			time.Sleep(cfg.WaitForHealthcheckInterval)
+			log.Info("Shutting down gracefully...")
+			break runLoop
+		case <-restart:
+			if err := reexec(ln, challengeLn); err != nil {
+				// The replacement never started, so this is still the only
+				// instance serving traffic — draining now would cause the
+				// exact outage zero-downtime restart exists to prevent.
+				// Stay up and keep waiting for a signal.
+				log.WithError(err).Error("graceful restart failed, continuing to serve")
+				continue runLoop
+			}
+			log.Info("Replacement process started, draining this instance...")
+			lbInstance.SetDraining(true)
+			time.Sleep(cfg.WaitForHealthcheckInterval)
+			log.Info("Shutting down gracefully...")
+			break runLoop
+		case err := <-errChan:
+			stopHealthChecks()
+			return err
+		}
 	}
 
-	// Give in-flight requests up to 5 seconds to complete before forcing shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	stopHealthChecks()
+
+	// Give in-flight requests up to HammerTimeout to complete before forcing shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.HammerTimeout)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
 		return fmt.Errorf("shutdown error: %w", err)
 	}
+	if challengeSrv != nil {
+		if err := challengeSrv.Shutdown(ctx); err != nil {
+			return fmt.Errorf("acme challenge shutdown error: %w", err)
+		}
+	}
 
 	log.Info("──────────────────────────────────────────────")
 	log.Info("Server stopped cleanly. Goodbye!")
@@ -51,3 +166,111 @@ func Run(addr string, handler http.Handler) error {
 
 	return nil
 }
+
+// inheritedFDCount reports how many listening sockets the parent passed down
+// via LISTEN_FDS/ExtraFiles (fd 3, 4, ...), or 0 if this isn't a re-exec.
+func inheritedFDCount() int {
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 || os.Getppid() <= 1 {
+		return 0
+	}
+	return n
+}
+
+// adoptFD wraps an inherited file descriptor (counting from 3, per
+// ExtraFiles convention) as a listener.
+func adoptFD(fd int) (net.Listener, error) {
+	f := os.NewFile(uintptr(fd), "listener")
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to adopt inherited listener (fd %d): %w", fd, err)
+	}
+	log.Infof("adopted listening socket from parent process (fd %d)", fd)
+	return ln, nil
+}
+
+// closeInheritedFD closes a socket inherited from a parent that this
+// generation of the process has no use for (e.g. ACME was disabled since
+// the last restart), so it doesn't leak as an open, unused listener for the
+// rest of the process's life.
+func closeInheritedFD(fd int) {
+	f := os.NewFile(uintptr(fd), "unused-listener")
+	if f == nil {
+		return
+	}
+	if err := f.Close(); err != nil {
+		log.WithError(err).Warnf("failed to close unused inherited fd %d", fd)
+		return
+	}
+	log.Infof("closed unused inherited fd %d", fd)
+}
+
+// listen adopts the main listener inherited from a parent via
+// LISTEN_FDS/ExtraFiles (fd 3) when present, otherwise binds a fresh
+// listener on addr. Adopting lets a re-exec'd binary keep serving on the
+// same socket with no dropped connections.
+func listen(addr string) (net.Listener, error) {
+	if inheritedFDCount() >= 1 {
+		return adoptFD(3)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// listenChallenge adopts the ACME HTTP-01 challenge listener inherited from
+// a parent (fd 4) when present, otherwise binds a fresh listener on addr.
+// Without this, a re-exec'd child would try to bind :80 fresh while the
+// parent still holds it during its drain window, breaking zero-downtime
+// restart whenever ACME is enabled.
+func listenChallenge(addr string) (net.Listener, error) {
+	if inheritedFDCount() >= 2 {
+		return adoptFD(4)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// reexec re-execs the current binary, passing ln (and challengeLn, if the
+// ACME challenge listener is in use) to it via ExtraFiles as fd 3 and fd 4
+// so the child can adopt them in listen()/listenChallenge() and start
+// accepting connections before this process gives up the sockets.
+func reexec(ln net.Listener, challengeLn net.Listener) error {
+	tcpLn, ok := ln.(*net.TCPListener)
+	if !ok {
+		return fmt.Errorf("listener does not support fd inheritance: %T", ln)
+	}
+
+	lnFile, err := tcpLn.File()
+	if err != nil {
+		return fmt.Errorf("failed to duplicate listener fd: %w", err)
+	}
+	defer lnFile.Close()
+
+	extraFiles := []*os.File{lnFile}
+	listenFDs := 1
+
+	if challengeLn != nil {
+		challengeTCPLn, ok := challengeLn.(*net.TCPListener)
+		if !ok {
+			return fmt.Errorf("challenge listener does not support fd inheritance: %T", challengeLn)
+		}
+		challengeLnFile, err := challengeTCPLn.File()
+		if err != nil {
+			return fmt.Errorf("failed to duplicate challenge listener fd: %w", err)
+		}
+		defer challengeLnFile.Close()
+		extraFiles = append(extraFiles, challengeLnFile)
+		listenFDs = 2
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to resolve executable path: %w", err)
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = extraFiles
+	cmd.Env = append(os.Environ(), fmt.Sprintf("LISTEN_FDS=%d", listenFDs))
+
+	return cmd.Start()
+}